@@ -0,0 +1,36 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	common_config "github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/config"
+)
+
+// defaultAgentRemoteFlushDeadline bounds how long an agent-mode tenant's remote-write
+// shard waits for in-flight samples to flush on shutdown, matching the default
+// Prometheus agent uses for the same setting.
+const defaultAgentRemoteFlushDeadline = 1 * time.Minute
+
+// remoteWriteConfigsFor builds the remote.Storage config that forwards an agent-mode
+// tenant's WAL to endpoints, one RemoteWriteConfig per endpoint with everything else
+// left at its default (queue sizing, retry backoff, HTTP client).
+func remoteWriteConfigsFor(endpoints []string) ([]*config.RemoteWriteConfig, error) {
+	cfgs := make([]*config.RemoteWriteConfig, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		parsed, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse remote-write endpoint %q", endpoint)
+		}
+
+		cfg := config.DefaultRemoteWriteConfig
+		cfg.URL = &common_config.URL{URL: parsed}
+		cfgs = append(cfgs, &cfg)
+	}
+	return cfgs, nil
+}
@@ -0,0 +1,55 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"sync"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// labelInternerCapacity bounds the number of entries a labelInterner holds. A
+// tenant that churns through more distinct cold series than this per lifetime
+// just stops getting cache hits for the oldest of them; it never grows the
+// interner past a fixed memory budget the way an unbounded map would.
+const labelInternerCapacity = 8192
+
+// labelInterner caches the durable labels.Labels built for a tenant's first-seen
+// series so that, once a series has been copied out of its request-scoped protobuf
+// buffer, later cold lookups for the same series (e.g. after it's evicted from the
+// TSDB's own ref cache) reuse that copy instead of paying for another
+// ReAllocZLabelsStrings. It holds at most labelInternerCapacity entries, evicting
+// the oldest-inserted one on overflow (FIFO), so its memory footprint is bounded
+// regardless of a tenant's series cardinality.
+type labelInterner struct {
+	mtx   sync.RWMutex
+	m     map[string]labels.Labels
+	order []string
+}
+
+func newLabelInterner() *labelInterner {
+	return &labelInterner{m: make(map[string]labels.Labels)}
+}
+
+func (in *labelInterner) get(key string) (labels.Labels, bool) {
+	in.mtx.RLock()
+	defer in.mtx.RUnlock()
+	lset, ok := in.m[key]
+	return lset, ok
+}
+
+func (in *labelInterner) put(key string, lset labels.Labels) {
+	in.mtx.Lock()
+	defer in.mtx.Unlock()
+	if _, ok := in.m[key]; ok {
+		return
+	}
+	if len(in.order) >= labelInternerCapacity {
+		var oldest string
+		oldest, in.order = in.order[0], in.order[1:]
+		delete(in.m, oldest)
+	}
+	in.m[key] = lset
+	in.order = append(in.order, key)
+}
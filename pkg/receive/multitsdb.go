@@ -0,0 +1,180 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/agent"
+)
+
+// TSDBOptions configures the per-tenant TSDBs that MultiTSDB creates.
+type TSDBOptions struct {
+	// WALCompression selects the codec used for new tenant WAL segments.
+	// Existing segments keep whatever codec they were written with: wlog
+	// tags each segment with its own codec and replays it accordingly, so
+	// switching this does not require migrating a tenant's WAL.
+	WALCompression WALCompressionType
+
+	// AgentRemoteWriteEndpoints are the remote-write URLs every agent-mode tenant's
+	// WAL is forwarded to. A tenant opened in TenantModeAgent with no endpoints
+	// configured accepts writes into its WAL but never ships them upstream, so its
+	// WAL is never truncated past what disk retention elsewhere enforces; this is
+	// only intended for tests, not production agent tenants.
+	AgentRemoteWriteEndpoints []string
+}
+
+// tsdbAppendable adapts a *tsdb.DB to the Appendable interface Writer depends on.
+type tsdbAppendable struct {
+	db *tsdb.DB
+}
+
+func (a *tsdbAppendable) Appender(ctx context.Context) (storage.Appender, error) {
+	return a.db.Appender(ctx), nil
+}
+
+// MultiTSDB owns one TSDB per tenant, opened lazily on first write, and implements
+// TenantStorage for receive.Writer.
+type MultiTSDB struct {
+	dataDir string
+	logger  log.Logger
+	reg     prometheus.Registerer
+	opts    TSDBOptions
+
+	mtx     sync.RWMutex
+	tenants map[string]Appendable
+
+	modeMtx     sync.RWMutex
+	tenantModes map[string]TenantMode
+}
+
+// NewMultiTSDB returns a MultiTSDB that creates tenant TSDBs under dataDir using opts.
+func NewMultiTSDB(dataDir string, logger log.Logger, reg prometheus.Registerer, opts TSDBOptions) *MultiTSDB {
+	return &MultiTSDB{
+		dataDir:     dataDir,
+		logger:      logger,
+		reg:         reg,
+		opts:        opts,
+		tenants:     map[string]Appendable{},
+		tenantModes: map[string]TenantMode{},
+	}
+}
+
+// SetTenantMode configures the storage mode used the next time tenantID's TSDB is
+// opened. It has no effect on a tenant whose Appendable has already been created;
+// callers set this ahead of a tenant's first write, e.g. from hashring config.
+func (t *MultiTSDB) SetTenantMode(tenantID string, mode TenantMode) {
+	t.modeMtx.Lock()
+	defer t.modeMtx.Unlock()
+	t.tenantModes[tenantID] = mode
+}
+
+func (t *MultiTSDB) modeFor(tenantID string) TenantMode {
+	t.modeMtx.RLock()
+	defer t.modeMtx.RUnlock()
+	if mode, ok := t.tenantModes[tenantID]; ok {
+		return mode
+	}
+	return TenantModeDefault
+}
+
+// TenantAppendable returns the Appendable for tenantID, opening its TSDB on first use.
+func (t *MultiTSDB) TenantAppendable(tenantID string) (Appendable, error) {
+	t.mtx.RLock()
+	a, ok := t.tenants[tenantID]
+	t.mtx.RUnlock()
+	if ok {
+		return a, nil
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if a, ok := t.tenants[tenantID]; ok {
+		return a, nil
+	}
+
+	a, err := t.openTenant(tenantID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open TSDB for tenant %q", tenantID)
+	}
+	t.tenants[tenantID] = a
+	return a, nil
+}
+
+func (t *MultiTSDB) openTenant(tenantID string) (Appendable, error) {
+	if t.modeFor(tenantID) == TenantModeAgent {
+		return t.openAgentTenant(tenantID)
+	}
+
+	compression, err := t.opts.WALCompression.Parse()
+	if err != nil {
+		return nil, errors.Wrap(err, "parse WAL compression")
+	}
+
+	tsdbOpts := tsdb.DefaultOptions()
+	tsdbOpts.WALCompression = compression
+
+	db, err := tsdb.Open(filepath.Join(t.dataDir, tenantID), t.logger, t.reg, tsdbOpts, tsdb.NewDBStats())
+	if err != nil {
+		return nil, err
+	}
+	return &tsdbAppendable{db: db}, nil
+}
+
+// openAgentTenant opens a WAL-only agent.DB for tenantID, skipping the cost of a
+// full TSDB head for tenants that only forward writes upstream, backed by a
+// remote.Storage shard that tails the WAL and ships samples to
+// t.opts.AgentRemoteWriteEndpoints.
+func (t *MultiTSDB) openAgentTenant(tenantID string) (Appendable, error) {
+	compression, err := t.opts.WALCompression.Parse()
+	if err != nil {
+		return nil, errors.Wrap(err, "parse WAL compression")
+	}
+
+	remoteWriteConfigs, err := remoteWriteConfigsFor(t.opts.AgentRemoteWriteEndpoints)
+	if err != nil {
+		return nil, errors.Wrap(err, "build agent remote-write config")
+	}
+
+	tenantDir := filepath.Join(t.dataDir, tenantID)
+	tenantLogger := log.With(t.logger, "tenant", tenantID)
+
+	// agent.DB asks rs for its lowest acked sample to decide how far it can
+	// truncate the WAL, so rs must exist before Open is called; its own
+	// start-time callback reports back into db once Open has returned it.
+	var db *agent.DB
+	rs := remote.NewStorage(tenantLogger, t.reg, func() (int64, error) {
+		if db == nil {
+			return 0, nil
+		}
+		return db.StartTime()
+	}, tenantDir, defaultAgentRemoteFlushDeadline, nil)
+
+	if err := rs.ApplyConfig(&config.Config{
+		GlobalConfig:       config.DefaultGlobalConfig,
+		RemoteWriteConfigs: remoteWriteConfigs,
+	}); err != nil {
+		rs.Close()
+		return nil, errors.Wrap(err, "configure agent remote-write shard")
+	}
+
+	agentOpts := agent.DefaultOptions()
+	agentOpts.WALCompression = compression
+
+	db, err = agent.Open(tenantLogger, t.reg, rs, tenantDir, agentOpts)
+	if err != nil {
+		rs.Close()
+		return nil, err
+	}
+	return &agentAppendable{db: db, rs: rs}, nil
+}
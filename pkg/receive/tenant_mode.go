@@ -0,0 +1,49 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"context"
+
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/prometheus/prometheus/tsdb/agent"
+)
+
+// TenantMode selects the storage backing a tenant's Appendable in the receive
+// multi-TSDB.
+type TenantMode string
+
+const (
+	// TenantModeDefault backs a tenant with a full TSDB head, enabling local
+	// querying and compaction to block storage.
+	TenantModeDefault TenantMode = "default"
+	// TenantModeAgent backs a tenant with a WAL-only prometheus/tsdb/agent.DB.
+	// Agent-mode tenants cannot be queried locally; they exist purely to accept
+	// and forward writes, which lets edge receivers skip the cost of a full head.
+	TenantModeAgent TenantMode = "agent"
+)
+
+// AgentAppendable is implemented by an Appendable whose underlying storage.Appender
+// is backed by a WAL-only agent.DB rather than a queryable TSDB head. TenantStorage
+// implementations can use this to report, per tenant, whether TenantAppendable
+// returned an agent-mode Appendable.
+type AgentAppendable interface {
+	Appendable
+	TenantMode() TenantMode
+}
+
+// agentAppendable adapts a *agent.DB to Appendable/AgentAppendable. Writes land in
+// the agent's WAL-only storage; rs is the remote-write shard, opened alongside db,
+// that tails that WAL and forwards samples to its configured endpoints.
+type agentAppendable struct {
+	db *agent.DB
+	rs *remote.Storage
+}
+
+func (a *agentAppendable) Appender(ctx context.Context) (storage.Appender, error) {
+	return a.db.Appender(ctx), nil
+}
+
+func (a *agentAppendable) TenantMode() TenantMode { return TenantModeAgent }
@@ -0,0 +1,54 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"fmt"
+
+	"github.com/prometheus/prometheus/tsdb/wlog"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// WALCompressionType selects the codec used for per-tenant TSDB WAL segments
+// created by the receive multi-TSDB.
+type WALCompressionType string
+
+const (
+	WALCompressionNone   WALCompressionType = "none"
+	WALCompressionSnappy WALCompressionType = "snappy"
+	WALCompressionZstd   WALCompressionType = "zstd"
+)
+
+// Parse validates a --receive.tsdb-wal-compression flag value and returns the
+// wlog.CompressionType it maps to. Existing WAL segments are tagged with their own
+// codec on disk, so changing this does not prevent wlog from replaying segments
+// written under a different setting.
+func (t WALCompressionType) Parse() (wlog.CompressionType, error) {
+	switch t {
+	case WALCompressionNone:
+		return wlog.CompressionNone, nil
+	case WALCompressionSnappy, "":
+		return wlog.CompressionSnappy, nil
+	case WALCompressionZstd:
+		return wlog.CompressionZstd, nil
+	default:
+		return wlog.CompressionNone, fmt.Errorf("unknown WAL compression type %q", string(t))
+	}
+}
+
+// walCompressionFlagName is the CLI flag name under which operators configure
+// WALCompression for tenant TSDBs created by MultiTSDB.
+const walCompressionFlagName = "receive.tsdb-wal-compression"
+
+// RegisterWALCompressionFlag registers --receive.tsdb-wal-compression on cmd and
+// returns the value it parses into, for passing to TSDBOptions. The `thanos receive`
+// command clause registers this alongside its other TSDB flags; this package only
+// owns the flag's definition and parsing, not the command that wires it up.
+func RegisterWALCompressionFlag(cmd *kingpin.CmdClause) *WALCompressionType {
+	compression := WALCompressionSnappy
+	cmd.Flag(walCompressionFlagName, "TSDB WAL compression algorithm to use for tenant WALs (none, snappy, zstd).").
+		Default(string(WALCompressionSnappy)).
+		EnumVar((*string)(&compression), string(WALCompressionNone), string(WALCompressionSnappy), string(WALCompressionZstd))
+	return &compression
+}
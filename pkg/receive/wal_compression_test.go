@@ -0,0 +1,34 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"testing"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func TestRegisterWALCompressionFlag(t *testing.T) {
+	app := kingpin.New("thanos", "")
+	cmd := app.Command("receive", "")
+	compression := RegisterWALCompressionFlag(cmd)
+
+	if _, err := app.Parse([]string{"receive"}); err != nil {
+		t.Fatalf("parse with no flag set: %v", err)
+	}
+	if *compression != WALCompressionSnappy {
+		t.Fatalf("got default %q, want %q", *compression, WALCompressionSnappy)
+	}
+
+	if _, err := app.Parse([]string{"receive", "--" + walCompressionFlagName, "zstd"}); err != nil {
+		t.Fatalf("parse with flag set: %v", err)
+	}
+	if *compression != WALCompressionZstd {
+		t.Fatalf("got %q, want %q", *compression, WALCompressionZstd)
+	}
+
+	if _, err := app.Parse([]string{"receive", "--" + walCompressionFlagName, "lz4"}); err == nil {
+		t.Fatal("expected error for an unsupported codec, got nil")
+	}
+}
@@ -5,11 +5,13 @@ package receive
 
 import (
 	"context"
+	"sync"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/pkg/errors"
 	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/histogram"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb"
 
@@ -30,13 +32,29 @@ type TenantStorage interface {
 type Writer struct {
 	logger    log.Logger
 	multiTSDB TenantStorage
+
+	internersMtx sync.Mutex
+	interners    map[string]*labelInterner
 }
 
 func NewWriter(logger log.Logger, multiTSDB TenantStorage) *Writer {
 	return &Writer{
 		logger:    logger,
 		multiTSDB: multiTSDB,
+		interners: map[string]*labelInterner{},
+	}
+}
+
+// interner returns tenantID's labelInterner, creating it on first use.
+func (r *Writer) interner(tenantID string) *labelInterner {
+	r.internersMtx.Lock()
+	defer r.internersMtx.Unlock()
+	in, ok := r.interners[tenantID]
+	if !ok {
+		in = newLabelInterner()
+		r.interners[tenantID] = in
 	}
+	return in
 }
 
 func (r *Writer) Write(ctx context.Context, tenantID string, wreq *prompb.WriteRequest) error {
@@ -54,6 +72,10 @@ func (r *Writer) Write(ctx context.Context, tenantID string, wreq *prompb.WriteR
 		numExemplarsOutOfOrder  = 0
 		numExemplarsDuplicate   = 0
 		numExemplarsLabelLength = 0
+
+		numHistogramsOutOfOrder  = 0
+		numHistogramsDuplicates  = 0
+		numHistogramsOutOfBounds = 0
 	)
 
 	s, err := r.multiTSDB.TenantAppendable(tenantID)
@@ -69,6 +91,7 @@ func (r *Writer) Write(ctx context.Context, tenantID string, wreq *prompb.WriteR
 		return errors.Wrap(err, "get appender")
 	}
 	getRef := app.(storage.GetRef)
+	interner := r.interner(tenantID)
 
 	var (
 		ref  storage.SeriesRef
@@ -78,17 +101,20 @@ func (r *Writer) Write(ctx context.Context, tenantID string, wreq *prompb.WriteR
 		// Check if time series labels are valid. If not, skip the time series
 		// and report the error.
 		if err := labelpb.ValidateLabels(t.Labels); err != nil {
-			lset := &labelpb.ZLabelSet{Labels: t.Labels}
+			// Pass a *ZLabelSet rather than calling String() up front: String() has a
+			// pointer receiver, so taking the address keeps it satisfying fmt.Stringer,
+			// and a filtered-out debug level skips encoding its fields entirely, so an
+			// eagerly computed string would be wasted work on the happy path.
 			switch err {
 			case labelpb.ErrOutOfOrderLabels:
 				numLabelsOutOfOrder++
-				level.Debug(tLogger).Log("msg", "Out of order labels in the label set", "lset", lset.String())
+				level.Debug(tLogger).Log("msg", "Out of order labels in the label set", "lset", &labelpb.ZLabelSet{Labels: t.Labels})
 			case labelpb.ErrDuplicateLabels:
 				numLabelsDuplicates++
-				level.Debug(tLogger).Log("msg", "Duplicate labels in the label set", "lset", lset.String())
+				level.Debug(tLogger).Log("msg", "Duplicate labels in the label set", "lset", &labelpb.ZLabelSet{Labels: t.Labels})
 			case labelpb.ErrEmptyLabels:
 				numLabelsEmpty++
-				level.Debug(tLogger).Log("msg", "Labels with empty name in the label set", "lset", lset.String())
+				level.Debug(tLogger).Log("msg", "Labels with empty name in the label set", "lset", &labelpb.ZLabelSet{Labels: t.Labels})
 			default:
 				level.Debug(tLogger).Log("msg", "Error validating labels", "err", err)
 			}
@@ -101,10 +127,18 @@ func (r *Writer) Write(ctx context.Context, tenantID string, wreq *prompb.WriteR
 		// Check if the TSDB has cached reference for those labels.
 		ref, lset = getRef.GetRef(lset)
 		if ref == 0 {
-			// If not, copy labels, as TSDB will hold those strings long term. Given no
-			// copy unmarshal we don't want to keep memory for whole protobuf, only for labels.
-			labelpb.ReAllocZLabelsStrings(&t.Labels)
-			lset = labelpb.ZLabelsToPromLabels(t.Labels)
+			// Not cached in the TSDB: see if we've already paid for a durable copy of
+			// this exact label set earlier in this tenant's lifetime before copying
+			// again, as TSDB will hold those strings long term. Given no copy unmarshal
+			// we don't want to keep memory for whole protobuf, only for labels.
+			key := lset.String()
+			if interned, ok := interner.get(key); ok {
+				lset = interned
+			} else {
+				labelpb.ReAllocZLabelsStrings(&t.Labels)
+				lset = labelpb.ZLabelsToPromLabels(t.Labels)
+				interner.put(key, lset)
+			}
 		}
 
 		// Append as many valid samples as possible, but keep track of the errors.
@@ -127,6 +161,39 @@ func (r *Writer) Write(ctx context.Context, tenantID string, wreq *prompb.WriteR
 			}
 		}
 
+		// Append as many valid histograms as possible, but keep track of the errors.
+		// This must run before the exemplar block below: a batch can carry only
+		// histograms for a series new to this tenant, and AppendExemplar requires
+		// the series to already exist (ref != 0).
+		for _, hp := range t.Histograms {
+			var (
+				ih *histogram.Histogram
+				fh *histogram.FloatHistogram
+			)
+			if hp.IsFloatHistogram() {
+				fh = hp.ToFloatHistogram()
+			} else {
+				ih = hp.ToIntHistogram()
+			}
+
+			ref, err = app.AppendHistogram(ref, lset, hp.Timestamp, ih, fh)
+			switch err {
+			case storage.ErrOutOfOrderSample:
+				numHistogramsOutOfOrder++
+				level.Debug(tLogger).Log("msg", "Out of order histogram", "lset", lset, "timestamp", hp.Timestamp)
+			case storage.ErrDuplicateSampleForTimestamp:
+				numHistogramsDuplicates++
+				level.Debug(tLogger).Log("msg", "Duplicate histogram for timestamp", "lset", lset, "timestamp", hp.Timestamp)
+			case storage.ErrOutOfBounds:
+				numHistogramsOutOfBounds++
+				level.Debug(tLogger).Log("msg", "Out of bounds histogram", "lset", lset, "timestamp", hp.Timestamp)
+			default:
+				if err != nil {
+					level.Debug(tLogger).Log("msg", "Error ingesting histogram", "err", err)
+				}
+			}
+		}
+
 		// Current implemetation of app.AppendExemplar doesn't create a new series, so it must be already present.
 		// We drop the exemplars in case the series doesn't exist.
 		if ref != 0 && len(t.Exemplars) > 0 {
@@ -199,6 +266,19 @@ func (r *Writer) Write(ctx context.Context, tenantID string, wreq *prompb.WriteR
 		errs.Add(errors.Wrapf(storage.ErrExemplarLabelLength, "add %d exemplars", numExemplarsLabelLength))
 	}
 
+	if numHistogramsOutOfOrder > 0 {
+		level.Warn(tLogger).Log("msg", "Error on ingesting out-of-order histograms", "numDropped", numHistogramsOutOfOrder)
+		errs.Add(errors.Wrapf(storage.ErrOutOfOrderSample, "add %d histograms", numHistogramsOutOfOrder))
+	}
+	if numHistogramsDuplicates > 0 {
+		level.Warn(tLogger).Log("msg", "Error on ingesting histograms with different value but same timestamp", "numDropped", numHistogramsDuplicates)
+		errs.Add(errors.Wrapf(storage.ErrDuplicateSampleForTimestamp, "add %d histograms", numHistogramsDuplicates))
+	}
+	if numHistogramsOutOfBounds > 0 {
+		level.Warn(tLogger).Log("msg", "Error on ingesting histograms that are too old or are too far into the future", "numDropped", numHistogramsOutOfBounds)
+		errs.Add(errors.Wrapf(storage.ErrOutOfBounds, "add %d histograms", numHistogramsOutOfBounds))
+	}
+
 	if err := app.Commit(); err != nil {
 		errs.Add(errors.Wrap(err, "commit samples"))
 	}
@@ -0,0 +1,213 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/metadata"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+)
+
+// fakeAppender is a storage.Appender that stores nothing and only hands out and
+// remembers a series ref per label set, so benchmarks measure Writer.Write's own
+// overhead rather than TSDB head cost.
+type fakeAppender struct {
+	refs map[string]storage.SeriesRef
+	next storage.SeriesRef
+}
+
+func newFakeAppender() *fakeAppender {
+	return &fakeAppender{refs: make(map[string]storage.SeriesRef)}
+}
+
+func (a *fakeAppender) GetRef(lset labels.Labels) (storage.SeriesRef, labels.Labels) {
+	if ref, ok := a.refs[lset.String()]; ok {
+		return ref, lset
+	}
+	return 0, lset
+}
+
+func (a *fakeAppender) Append(ref storage.SeriesRef, lset labels.Labels, _ int64, _ float64) (storage.SeriesRef, error) {
+	if ref != 0 {
+		return ref, nil
+	}
+	a.next++
+	a.refs[lset.String()] = a.next
+	return a.next, nil
+}
+
+func (a *fakeAppender) AppendExemplar(ref storage.SeriesRef, _ labels.Labels, _ exemplar.Exemplar) (storage.SeriesRef, error) {
+	return ref, nil
+}
+
+func (a *fakeAppender) AppendHistogram(ref storage.SeriesRef, lset labels.Labels, _ int64, _ *histogram.Histogram, _ *histogram.FloatHistogram) (storage.SeriesRef, error) {
+	return a.Append(ref, lset, 0, 0)
+}
+
+func (a *fakeAppender) UpdateMetadata(ref storage.SeriesRef, _ labels.Labels, _ metadata.Metadata) (storage.SeriesRef, error) {
+	return ref, nil
+}
+
+func (a *fakeAppender) Commit() error   { return nil }
+func (a *fakeAppender) Rollback() error { return nil }
+
+type fakeAppendable struct{ app storage.Appender }
+
+func (f *fakeAppendable) Appender(context.Context) (storage.Appender, error) { return f.app, nil }
+
+type fakeTenantStorage struct{ appendable *fakeAppendable }
+
+func (f *fakeTenantStorage) TenantAppendable(string) (Appendable, error) { return f.appendable, nil }
+
+// genWriteRequest builds a remote-write payload with numSeries series, each
+// carrying samplesPerSeries plain samples and no histograms, mimicking the
+// high-series, low-samples-per-series shape a Prometheus agent typically forwards.
+func genWriteRequest(numSeries, samplesPerSeries int) *prompb.WriteRequest {
+	return genWriteRequestWithHistograms(numSeries, samplesPerSeries, 0)
+}
+
+// genWriteRequestWithHistograms is genWriteRequest with histogramsPerSeries native
+// histograms added to each series.
+func genWriteRequestWithHistograms(numSeries, samplesPerSeries, histogramsPerSeries int) *prompb.WriteRequest {
+	wreq := &prompb.WriteRequest{Timeseries: make([]prompb.TimeSeries, 0, numSeries)}
+	for i := 0; i < numSeries; i++ {
+		lbls := labelpb.ZLabelsFromPromLabels(labels.FromStrings(
+			"__name__", "benchmark_metric",
+			"instance", fmt.Sprintf("instance-%d", i),
+			"job", "bench",
+		))
+		samples := make([]prompb.Sample, 0, samplesPerSeries)
+		for j := 0; j < samplesPerSeries; j++ {
+			samples = append(samples, prompb.Sample{Value: float64(j), Timestamp: int64(j)})
+		}
+		histograms := make([]prompb.Histogram, 0, histogramsPerSeries)
+		for j := 0; j < histogramsPerSeries; j++ {
+			histograms = append(histograms, genHistogram(int64(j), j%2 == 0))
+		}
+		wreq.Timeseries = append(wreq.Timeseries, prompb.TimeSeries{Labels: lbls, Samples: samples, Histograms: histograms})
+	}
+	return wreq
+}
+
+// genHistogram builds a minimal native histogram sample, alternating between the
+// integer and float counter variants depending on float.
+func genHistogram(ts int64, float bool) prompb.Histogram {
+	h := prompb.Histogram{Timestamp: ts, Schema: 3, ZeroThreshold: 0.001}
+	if float {
+		h.Count = &prompb.Histogram_CountFloat{CountFloat: 10}
+	} else {
+		h.Count = &prompb.Histogram_CountInt{CountInt: 10}
+	}
+	return h
+}
+
+// coldRefAppender wraps fakeAppender but always reports GetRef misses, simulating
+// a tenant whose TSDB ref cache never warms for a given series (e.g. it was just
+// evicted), so Write repeatedly hits the ReAllocZLabelsStrings/intern branch.
+type coldRefAppender struct {
+	*fakeAppender
+}
+
+func (a *coldRefAppender) GetRef(lset labels.Labels) (storage.SeriesRef, labels.Labels) {
+	return 0, lset
+}
+
+func TestWriter_Write_InternsRepeatedColdSeries(t *testing.T) {
+	app := &coldRefAppender{fakeAppender: newFakeAppender()}
+	w := NewWriter(log.NewNopLogger(), &fakeTenantStorage{appendable: &fakeAppendable{app: app}})
+	wreq := genWriteRequest(1, 1)
+
+	if err := w.Write(context.Background(), "tenant-a", wreq); err != nil {
+		t.Fatalf("first write: %v", err)
+	}
+	if err := w.Write(context.Background(), "tenant-a", wreq); err != nil {
+		t.Fatalf("second write: %v", err)
+	}
+
+	in := w.interner("tenant-a")
+	if got := len(in.m); got != 1 {
+		t.Fatalf("expected the interner to hold exactly 1 entry for one repeated cold series, got %d", got)
+	}
+}
+
+func TestWriter_Write_InternerEvictsOldestBeyondCapacity(t *testing.T) {
+	app := &coldRefAppender{fakeAppender: newFakeAppender()}
+	w := NewWriter(log.NewNopLogger(), &fakeTenantStorage{appendable: &fakeAppendable{app: app}})
+
+	// One more distinct series than the interner's capacity: the first series'
+	// entry must be evicted to admit the last one.
+	wreq := genWriteRequest(labelInternerCapacity+1, 1)
+	if err := w.Write(context.Background(), "tenant-a", wreq); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	in := w.interner("tenant-a")
+	if got := len(in.m); got != labelInternerCapacity {
+		t.Fatalf("expected the interner to be capped at %d entries, got %d", labelInternerCapacity, got)
+	}
+
+	firstKey := labelpb.ZLabelsToPromLabels(genWriteRequest(1, 1).Timeseries[0].Labels).String()
+	if _, ok := in.get(firstKey); ok {
+		t.Fatal("expected the oldest entry to have been evicted, but it's still present")
+	}
+}
+
+func BenchmarkWriter_Write(b *testing.B) {
+	for _, bc := range []struct {
+		numSeries        int
+		samplesPerSeries int
+	}{
+		{numSeries: 1000, samplesPerSeries: 1},
+		{numSeries: 10000, samplesPerSeries: 1},
+		{numSeries: 10000, samplesPerSeries: 10},
+	} {
+		b.Run(fmt.Sprintf("series=%d/samplesPerSeries=%d", bc.numSeries, bc.samplesPerSeries), func(b *testing.B) {
+			w := NewWriter(log.NewNopLogger(), &fakeTenantStorage{appendable: &fakeAppendable{app: newFakeAppender()}})
+			wreq := genWriteRequest(bc.numSeries, bc.samplesPerSeries)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := w.Write(context.Background(), "default-tenant", wreq); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkWriter_WriteHistograms exercises the native-histogram append path added
+// alongside plain samples in the same batch.
+func BenchmarkWriter_WriteHistograms(b *testing.B) {
+	for _, bc := range []struct {
+		numSeries           int
+		histogramsPerSeries int
+	}{
+		{numSeries: 1000, histogramsPerSeries: 1},
+		{numSeries: 10000, histogramsPerSeries: 1},
+	} {
+		b.Run(fmt.Sprintf("series=%d/histogramsPerSeries=%d", bc.numSeries, bc.histogramsPerSeries), func(b *testing.B) {
+			w := NewWriter(log.NewNopLogger(), &fakeTenantStorage{appendable: &fakeAppendable{app: newFakeAppender()}})
+			wreq := genWriteRequestWithHistograms(bc.numSeries, 0, bc.histogramsPerSeries)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := w.Write(context.Background(), "default-tenant", wreq); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/metadata"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+
+	"github.com/thanos-io/thanos/pkg/errutil"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+)
+
+// RemoteWriteVersion identifies the wire format of an incoming remote-write request.
+type RemoteWriteVersion int
+
+const (
+	// RemoteWriteVersion1 is the original, non-symbol-table-encoded protocol.
+	RemoteWriteVersion1 RemoteWriteVersion = iota
+	// RemoteWriteVersion2 is the symbol-table-encoded Remote-Write 2.0 protocol.
+	RemoteWriteVersion2
+)
+
+const (
+	remoteWriteVersionHeader = "X-Prometheus-Remote-Write-Version"
+	remoteWriteV2Proto       = "io.prometheus.write.v2.Request"
+)
+
+// errLabelRefOutOfRange is returned for a series whose LabelsRefs is malformed: an
+// odd length, or a name/value ref past the end of the request's symbol table.
+var errLabelRefOutOfRange = errors.New("label reference out of range in the symbol table")
+
+// AppenderIdentifyingLabels is implemented by appenders that can persist a series'
+// identifying labels (e.g. created-timestamp/identity markers) independently of its
+// samples, as introduced upstream to support Remote-Write 2.0.
+type AppenderIdentifyingLabels interface {
+	AppendIdentifyingLabels(ref storage.SeriesRef, names []string, t int64) (storage.SeriesRef, error)
+}
+
+// NegotiateWriteVersion inspects the Content-Type and X-Prometheus-Remote-Write-Version
+// headers of an incoming request and reports which wire format the Writer should decode
+// it as. Clients that don't advertise a version are assumed to speak 1.0, preserving
+// compatibility with every Prometheus/Thanos release that predates 2.0 support.
+func NegotiateWriteVersion(header http.Header) (RemoteWriteVersion, error) {
+	if v := header.Get(remoteWriteVersionHeader); v != "" {
+		if strings.HasPrefix(v, "2.") {
+			return RemoteWriteVersion2, nil
+		}
+		return RemoteWriteVersion1, nil
+	}
+
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		return RemoteWriteVersion1, nil
+	}
+
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return RemoteWriteVersion1, errors.Wrap(err, "parse content type")
+	}
+	if params["proto"] == remoteWriteV2Proto {
+		return RemoteWriteVersion2, nil
+	}
+	return RemoteWriteVersion1, nil
+}
+
+// WriteV2 ingests a Remote-Write 2.0 request. Unlike Write, series in wreq reference
+// their labels and metadata as offsets into wreq.Symbols, so the caller doesn't pay for
+// repeated label string allocation across series that share a symbol table.
+func (r *Writer) WriteV2(ctx context.Context, tenantID string, wreq *writev2.Request) error {
+	tLogger := log.With(r.logger, "tenant", tenantID)
+
+	var (
+		numLabelsDuplicates = 0
+		numLabelsEmpty      = 0
+		numLabelsInvalidRef = 0
+
+		numSamplesOutOfOrder  = 0
+		numSamplesDuplicates  = 0
+		numSamplesOutOfBounds = 0
+
+		numHistogramsOutOfOrder  = 0
+		numHistogramsDuplicates  = 0
+		numHistogramsOutOfBounds = 0
+	)
+
+	s, err := r.multiTSDB.TenantAppendable(tenantID)
+	if err != nil {
+		return errors.Wrap(err, "get tenant appendable")
+	}
+
+	app, err := s.Appender(ctx)
+	if err == tsdb.ErrNotReady {
+		return err
+	}
+	if err != nil {
+		return errors.Wrap(err, "get appender")
+	}
+	getRef := app.(storage.GetRef)
+	idApp, hasIdentifyingLabels := app.(AppenderIdentifyingLabels)
+
+	symbols := wreq.Symbols
+	var (
+		ref  storage.SeriesRef
+		errs errutil.MultiError
+		b    labels.ScratchBuilder
+	)
+	for _, t := range wreq.Timeseries {
+		lset, ok := decodeV2Labels(&b, symbols, t.LabelsRefs)
+		if !ok {
+			numLabelsInvalidRef++
+			level.Debug(tLogger).Log("msg", "Label reference out of range in the symbol table", "numLabelRefs", len(t.LabelsRefs))
+			continue
+		}
+		emptyNameOrValue := lset.IsEmpty()
+		lset.Range(func(l labels.Label) {
+			if l.Name == "" || l.Value == "" {
+				emptyNameOrValue = true
+			}
+		})
+		if emptyNameOrValue {
+			numLabelsEmpty++
+			level.Debug(tLogger).Log("msg", "Labels with empty name in the label set", "lset", lset.String())
+			continue
+		}
+		if lset.HasDuplicateLabelNames() {
+			numLabelsDuplicates++
+			level.Debug(tLogger).Log("msg", "Duplicate labels in the label set", "lset", lset.String())
+			continue
+		}
+
+		// Check if the TSDB has a cached reference for those labels, same as the 1.0 path.
+		ref, lset = getRef.GetRef(lset)
+
+		for _, sa := range t.Samples {
+			ref, err = app.Append(ref, lset, sa.Timestamp, sa.Value)
+			switch err {
+			case storage.ErrOutOfOrderSample:
+				numSamplesOutOfOrder++
+				level.Debug(tLogger).Log("msg", "Out of order sample", "lset", lset, "value", sa.Value, "timestamp", sa.Timestamp)
+			case storage.ErrDuplicateSampleForTimestamp:
+				numSamplesDuplicates++
+				level.Debug(tLogger).Log("msg", "Duplicate sample for timestamp", "lset", lset, "value", sa.Value, "timestamp", sa.Timestamp)
+			case storage.ErrOutOfBounds:
+				numSamplesOutOfBounds++
+				level.Debug(tLogger).Log("msg", "Out of bounds metric", "lset", lset, "value", sa.Value, "timestamp", sa.Timestamp)
+			default:
+				if err != nil {
+					level.Debug(tLogger).Log("msg", "Error ingesting sample", "err", err)
+				}
+			}
+		}
+
+		for _, hp := range t.Histograms {
+			var (
+				ih *histogram.Histogram
+				fh *histogram.FloatHistogram
+			)
+			if hp.IsFloatHistogram() {
+				fh = hp.ToFloatHistogram()
+			} else {
+				ih = hp.ToIntHistogram()
+			}
+
+			ref, err = app.AppendHistogram(ref, lset, hp.Timestamp, ih, fh)
+			switch err {
+			case storage.ErrOutOfOrderSample:
+				numHistogramsOutOfOrder++
+				level.Debug(tLogger).Log("msg", "Out of order histogram", "lset", lset, "timestamp", hp.Timestamp)
+			case storage.ErrDuplicateSampleForTimestamp:
+				numHistogramsDuplicates++
+				level.Debug(tLogger).Log("msg", "Duplicate histogram for timestamp", "lset", lset, "timestamp", hp.Timestamp)
+			case storage.ErrOutOfBounds:
+				numHistogramsOutOfBounds++
+				level.Debug(tLogger).Log("msg", "Out of bounds histogram", "lset", lset, "timestamp", hp.Timestamp)
+			default:
+				if err != nil {
+					level.Debug(tLogger).Log("msg", "Error ingesting histogram", "err", err)
+				}
+			}
+		}
+
+		if ref != 0 {
+			if _, err := app.UpdateMetadata(ref, lset, metadata.Metadata{
+				Type: metricTypeFromV2(t.Metadata.Type),
+				Help: symbolAt(symbols, t.Metadata.HelpRef),
+				Unit: symbolAt(symbols, t.Metadata.UnitRef),
+			}); err != nil {
+				level.Debug(tLogger).Log("msg", "Error updating metadata", "err", err)
+			}
+
+			if hasIdentifyingLabels && len(t.Samples) > 0 {
+				if _, err := idApp.AppendIdentifyingLabels(ref, lset.Names(), t.Samples[0].Timestamp); err != nil {
+					level.Debug(tLogger).Log("msg", "Error appending identifying labels", "err", err)
+				}
+			}
+		}
+	}
+
+	if numLabelsDuplicates > 0 {
+		level.Warn(tLogger).Log("msg", "Error on series with duplicate labels", "numDropped", numLabelsDuplicates)
+		errs.Add(errors.Wrapf(labelpb.ErrDuplicateLabels, "add %d series", numLabelsDuplicates))
+	}
+	if numLabelsEmpty > 0 {
+		level.Warn(tLogger).Log("msg", "Error on series with empty label name or value", "numDropped", numLabelsEmpty)
+		errs.Add(errors.Wrapf(labelpb.ErrEmptyLabels, "add %d series", numLabelsEmpty))
+	}
+	if numLabelsInvalidRef > 0 {
+		level.Warn(tLogger).Log("msg", "Error on series with a label reference past the end of the symbol table", "numDropped", numLabelsInvalidRef)
+		errs.Add(errors.Wrapf(errLabelRefOutOfRange, "add %d series", numLabelsInvalidRef))
+	}
+	if numSamplesOutOfOrder > 0 {
+		level.Warn(tLogger).Log("msg", "Error on ingesting out-of-order samples", "numDropped", numSamplesOutOfOrder)
+		errs.Add(errors.Wrapf(storage.ErrOutOfOrderSample, "add %d samples", numSamplesOutOfOrder))
+	}
+	if numSamplesDuplicates > 0 {
+		level.Warn(tLogger).Log("msg", "Error on ingesting samples with different value but same timestamp", "numDropped", numSamplesDuplicates)
+		errs.Add(errors.Wrapf(storage.ErrDuplicateSampleForTimestamp, "add %d samples", numSamplesDuplicates))
+	}
+	if numSamplesOutOfBounds > 0 {
+		level.Warn(tLogger).Log("msg", "Error on ingesting samples that are too old or are too far into the future", "numDropped", numSamplesOutOfBounds)
+		errs.Add(errors.Wrapf(storage.ErrOutOfBounds, "add %d samples", numSamplesOutOfBounds))
+	}
+	if numHistogramsOutOfOrder > 0 {
+		level.Warn(tLogger).Log("msg", "Error on ingesting out-of-order histograms", "numDropped", numHistogramsOutOfOrder)
+		errs.Add(errors.Wrapf(storage.ErrOutOfOrderSample, "add %d histograms", numHistogramsOutOfOrder))
+	}
+	if numHistogramsDuplicates > 0 {
+		level.Warn(tLogger).Log("msg", "Error on ingesting histograms with different value but same timestamp", "numDropped", numHistogramsDuplicates)
+		errs.Add(errors.Wrapf(storage.ErrDuplicateSampleForTimestamp, "add %d histograms", numHistogramsDuplicates))
+	}
+	if numHistogramsOutOfBounds > 0 {
+		level.Warn(tLogger).Log("msg", "Error on ingesting histograms that are too old or are too far into the future", "numDropped", numHistogramsOutOfBounds)
+		errs.Add(errors.Wrapf(storage.ErrOutOfBounds, "add %d histograms", numHistogramsOutOfBounds))
+	}
+
+	if err := app.Commit(); err != nil {
+		errs.Add(errors.Wrap(err, "commit samples"))
+	}
+	return errs.Err()
+}
+
+// decodeV2Labels resolves a series' label references against the request-wide symbol
+// table, reusing b across calls so series in the same request don't each allocate a
+// fresh builder. It reports ok=false, without panicking, for a malformed series: an
+// odd-length labelRefs, or a name/value ref past the end of symbols.
+func decodeV2Labels(b *labels.ScratchBuilder, symbols []string, labelRefs []uint32) (lset labels.Labels, ok bool) {
+	if len(labelRefs)%2 != 0 {
+		return labels.EmptyLabels(), false
+	}
+	b.Reset()
+	for i := 0; i < len(labelRefs); i += 2 {
+		nameRef, valueRef := labelRefs[i], labelRefs[i+1]
+		if int(nameRef) >= len(symbols) || int(valueRef) >= len(symbols) {
+			return labels.EmptyLabels(), false
+		}
+		b.Add(symbols[nameRef], symbols[valueRef])
+	}
+	b.Sort()
+	return b.Labels(), true
+}
+
+func symbolAt(symbols []string, ref uint32) string {
+	if int(ref) >= len(symbols) {
+		return ""
+	}
+	return symbols[ref]
+}
+
+func metricTypeFromV2(t writev2.Metadata_MetricType) metadata.Type {
+	switch t {
+	case writev2.Metadata_METRIC_TYPE_COUNTER:
+		return metadata.Counter
+	case writev2.Metadata_METRIC_TYPE_GAUGE:
+		return metadata.Gauge
+	case writev2.Metadata_METRIC_TYPE_HISTOGRAM:
+		return metadata.Histogram
+	case writev2.Metadata_METRIC_TYPE_GAUGEHISTOGRAM:
+		return metadata.GaugeHistogram
+	case writev2.Metadata_METRIC_TYPE_SUMMARY:
+		return metadata.Summary
+	case writev2.Metadata_METRIC_TYPE_INFO:
+		return metadata.Info
+	case writev2.Metadata_METRIC_TYPE_STATESET:
+		return metadata.Stateset
+	default:
+		return metadata.Unknown
+	}
+}